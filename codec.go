@@ -0,0 +1,128 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sysutil
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"io"
+	"math"
+	"os"
+	"strings"
+)
+
+// CompressionCodec recognises and decodes one compressed log format.
+// Codecs are looked up by the file suffix they claim, e.g. ".gz".
+type CompressionCodec interface {
+	// Suffix returns the file suffix this codec owns.
+	Suffix() string
+	// NewReader returns a reader that decompresses the whole file.
+	NewReader(r io.ReaderAt, size int64) (io.ReadCloser, error)
+}
+
+// TimeRangeCodec is an optional capability of a CompressionCodec: codecs
+// that embed an index or header recording the time range of the
+// decompressed content can implement it so resolveFiles can bound a
+// compressed file's time range without decompressing its payload.
+type TimeRangeCodec interface {
+	CompressionCodec
+	TimeRange(r io.ReaderAt, size int64) (firstTime, lastTime int64, err error)
+}
+
+// SeekableCodec is an optional capability of a CompressionCodec: codecs
+// whose framing allows decoding a sub-range directly can implement it so
+// logIterator can skip straight to the data overlapping a time range
+// instead of decompressing from the start of the file.
+type SeekableCodec interface {
+	CompressionCodec
+	SeekToOffset(r io.ReaderAt, size, begin, end int64) (io.ReadCloser, error)
+}
+
+var codecRegistry = make(map[string]CompressionCodec)
+
+// RegisterCompressionCodec registers codec under its own Suffix, so that
+// resolveFiles and logIterator can recognise and decode files with that
+// suffix. Registering a codec for an already-registered suffix replaces
+// the previous one.
+func RegisterCompressionCodec(codec CompressionCodec) {
+	codecRegistry[codec.Suffix()] = codec
+}
+
+// codecForPath returns the codec owning path's suffix, if any.
+func codecForPath(path string) (CompressionCodec, bool) {
+	for suffix, codec := range codecRegistry {
+		if strings.HasSuffix(path, suffix) {
+			return codec, true
+		}
+	}
+	return nil, false
+}
+
+func init() {
+	RegisterCompressionCodec(gzipCodec{})
+	RegisterCompressionCodec(newZstdChunkedCodec())
+}
+
+// timeRangeForCompressed bounds the time range of a compressed logFile
+// candidate. It prefers a TimeRangeCodec's embedded index or header, in
+// which case the file's LogParser is left unset and is sniffed lazily
+// when the iterator first reads the file. If the codec doesn't
+// implement TimeRangeCodec, or the file has none, it falls back to
+// decompressing just far enough to sniff the parser and read the first
+// valid line, and assumes the file's last timestamp is unbounded.
+func timeRangeForCompressed(ctx context.Context, file *os.File, codec CompressionCodec) (firstTime, lastTime int64, parser LogParser, err error) {
+	stat, err := file.Stat()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	if trc, ok := codec.(TimeRangeCodec); ok {
+		if first, last, err := trc.TimeRange(file, stat.Size()); err == nil {
+			return first, last, nil, nil
+		}
+	}
+
+	rc, err := codec.NewReader(file, stat.Size())
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	defer rc.Close()
+	parser, firstItem, err := SniffFile(ctx, bufio.NewReader(rc), 10)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	// Without an index or header, finding the real last timestamp
+	// requires decompressing the whole file, so assume it's unbounded.
+	return firstItem.Time, math.MaxInt64, parser, nil
+}
+
+// gzipCodec adapts compress/gzip to CompressionCodec. It also implements
+// TimeRangeCodec, reading the time range written by CompressLogFile into
+// the gzip header's Extra field.
+type gzipCodec struct{}
+
+func (gzipCodec) Suffix() string { return compressSuffix }
+
+func (gzipCodec) NewReader(r io.ReaderAt, size int64) (io.ReadCloser, error) {
+	return gzip.NewReader(io.NewSectionReader(r, 0, size))
+}
+
+func (gzipCodec) TimeRange(r io.ReaderAt, size int64) (firstTime, lastTime int64, err error) {
+	meta, err := readGzipMetadata(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return 0, 0, err
+	}
+	return meta.FirstTime, meta.LastTime, nil
+}
@@ -0,0 +1,137 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sysutil
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+)
+
+// gzipMetadataSubfieldID is the gzip Extra subfield ID used to tag the
+// time-range metadata written by CompressLogFile. It follows the same
+// convention as Docker's rotateFileMetadata.
+const gzipMetadataSubfieldID = "TC"
+
+// logFileMetadata records the time range covered by a rotated log file.
+// It is stored in the gzip header's Extra field so that resolveFiles can
+// decide whether a compressed file overlaps a query range without
+// decompressing it.
+type logFileMetadata struct {
+	FirstTime int64 `json:"first_time"`
+	LastTime  int64 `json:"last_time"`
+}
+
+// CompressLogFile gzips the rotated log file at src into dst, recording
+// the unix-ms timestamps of its first and last valid log lines in the
+// gzip header's Extra field. resolveFiles uses this metadata to skip
+// decompressing archives that fall outside a requested time range.
+func CompressLogFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	var meta logFileMetadata
+	var haveMeta bool
+	if parser, firstItem, err := SniffFile(context.Background(), bufio.NewReader(in), 10); err == nil {
+		if _, err := in.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if lastItem, err := readLastValidLog(context.Background(), in, 10, parser); err == nil {
+			meta.FirstTime = firstItem.Time
+			meta.LastTime = lastItem.Time
+			haveMeta = true
+		}
+	}
+	if _, err := in.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	gw, _ := gzip.NewWriterLevel(out, gzip.BestSpeed)
+	if haveMeta {
+		metaBytes, err := json.Marshal(meta)
+		if err != nil {
+			return err
+		}
+		gw.Extra = encodeGzipExtraSubfield(gzipMetadataSubfieldID, metaBytes)
+	}
+	if _, err := io.Copy(gw, in); err != nil {
+		_ = gw.Close()
+		return err
+	}
+	return gw.Close()
+}
+
+// readGzipMetadata parses the logFileMetadata previously written by
+// CompressLogFile from r's gzip header, without decompressing the
+// stream.
+func readGzipMetadata(r io.Reader) (*logFileMetadata, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gr.Close()
+
+	data, ok := decodeGzipExtraSubfield(gr.Header.Extra, gzipMetadataSubfieldID)
+	if !ok {
+		return nil, errors.New("no time-range metadata in gzip header")
+	}
+	var meta logFileMetadata
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+// encodeGzipExtraSubfield packs data into a single gzip Extra subfield
+// with the given two-byte id, following the gzip FEXTRA subfield layout:
+// a 2-byte id, a little-endian 2-byte length, and the payload.
+func encodeGzipExtraSubfield(id string, data []byte) []byte {
+	buf := make([]byte, 4+len(data))
+	copy(buf[0:2], id)
+	binary.LittleEndian.PutUint16(buf[2:4], uint16(len(data)))
+	copy(buf[4:], data)
+	return buf
+}
+
+// decodeGzipExtraSubfield scans a gzip Extra field for the subfield
+// matching id and returns its payload.
+func decodeGzipExtraSubfield(extra []byte, id string) ([]byte, bool) {
+	for len(extra) >= 4 {
+		subID := string(extra[0:2])
+		length := int(binary.LittleEndian.Uint16(extra[2:4]))
+		if length > len(extra)-4 {
+			return nil, false
+		}
+		if subID == id {
+			return extra[4 : 4+length], true
+		}
+		extra = extra[4+length:]
+	}
+	return nil, false
+}
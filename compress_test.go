@@ -0,0 +1,132 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sysutil
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const compressTestLog = `[2019/08/26 06:19:13.011 -04:00] [INFO] [printer.go:41] ["Welcome to TiDB."]
+[2019/08/26 07:19:49.529 -04:00] [INFO] [printer.go:41] ["Release Version"=v3.0.2]
+[2019/08/26 08:20:23.815 -04:00] [INFO] [mod.rs:28] ["Release Version:   3.0.2"]
+`
+
+func TestCompressLogFileRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "tidb.log")
+	dst := filepath.Join(dir, "tidb.log.gz")
+	if err := os.WriteFile(src, []byte(compressTestLog), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := CompressLogFile(src, dst); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	meta, err := readGzipMetadata(f)
+	if err != nil {
+		t.Fatalf("readGzipMetadata: %v", err)
+	}
+	wantFirst, err := parseTimeStamp("2019/08/26 06:19:13.011 -04:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantLast, err := parseTimeStamp("2019/08/26 08:20:23.815 -04:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.FirstTime != wantFirst {
+		t.Errorf("FirstTime = %d, want %d", meta.FirstTime, wantFirst)
+	}
+	if meta.LastTime != wantLast {
+		t.Errorf("LastTime = %d, want %d", meta.LastTime, wantLast)
+	}
+}
+
+// TestResolveFilesSkipsOutOfRangeCompressedFile confirms a compressed
+// file whose gzip-header metadata falls entirely before the requested
+// range is excluded by resolveFiles.
+func TestResolveFilesSkipsOutOfRangeCompressedFile(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "tidb.log")
+	dst := filepath.Join(dir, "tidb.log.gz")
+	if err := os.WriteFile(src, []byte(compressTestLog), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := CompressLogFile(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(src); err != nil {
+		t.Fatal(err)
+	}
+
+	lastTime, err := parseTimeStamp("2019/08/26 08:20:23.815 -04:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logFiles, err := resolveFiles(context.Background(), src, lastTime+1000, lastTime+2000, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logFiles) != 0 {
+		t.Fatalf("expected no files to match a range entirely after the log, got %d", len(logFiles))
+	}
+
+	firstTime, err := parseTimeStamp("2019/08/26 06:19:13.011 -04:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logFiles, err = resolveFiles(context.Background(), src, firstTime, lastTime, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logFiles) != 1 {
+		t.Fatalf("expected the file to match an overlapping range, got %d", len(logFiles))
+	}
+	for _, lf := range logFiles {
+		_ = lf.file.Close()
+	}
+}
+
+func TestCompressLogFileUnparseableSourceOmitsMetadata(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "garbage.log")
+	dst := filepath.Join(dir, "garbage.log.gz")
+	if err := os.WriteFile(src, []byte("not a valid log line\nnor is this\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := CompressLogFile(src, dst); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if _, err := readGzipMetadata(f); err == nil {
+		t.Fatal("expected readGzipMetadata to fail for an unparseable source, so callers fall back to decompression")
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sysutil
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// waitForMore blocks until the active log file has more data to read, a
+// new rotated file appears, or FollowIdleTimeout elapses with no
+// activity (in which case it returns io.EOF to end the follow).
+func (iter *logIterator) waitForMore(ctx context.Context) error {
+	if iter.watcher == nil {
+		w, err := fsnotify.NewWatcher()
+		if err != nil {
+			return err
+		}
+		if err := w.Add(iter.logDir); err != nil {
+			_ = w.Close()
+			return err
+		}
+		iter.watcher = w
+	}
+
+	var idle <-chan time.Time
+	if iter.FollowIdleTimeout > 0 {
+		timer := time.NewTimer(iter.FollowIdleTimeout)
+		defer timer.Stop()
+		idle = timer.C
+	}
+
+	cur := &iter.pending[iter.fileIndex]
+	curName := cur.file.Name()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-idle:
+			return io.EOF
+		case err := <-iter.watcher.Errors:
+			return err
+		case event := <-iter.watcher.Events:
+			switch {
+			case event.Name == curName && event.Op&fsnotify.Write != 0:
+				iter.resumeAfterWrite(cur)
+				return nil
+			case event.Name == curName && event.Op&(fsnotify.Rename|fsnotify.Remove) != 0:
+				// The active file was rotated away; reopen it by name so
+				// we keep reading from the same inode's replacement, or
+				// block again if it hasn't reappeared yet.
+				f, err := os.Open(curName)
+				if err != nil {
+					continue
+				}
+				_ = cur.file.Close()
+				cur.file = f
+				iter.reader = bufio.NewReader(f)
+				return nil
+			case event.Op&fsnotify.Create != 0 && iter.isOwnRotatedFile(event.Name):
+				f, err := os.Open(event.Name)
+				if err != nil {
+					continue
+				}
+				if iter.trySniffCandidate(ctx, event.Name, f) {
+					return nil
+				}
+			case iter.pendingCandidates[event.Name] != nil && event.Op&fsnotify.Write != 0:
+				// A rotator typically creates the new file empty and
+				// writes to it moments later; retry the sniff that failed
+				// on the Create event now that there may be data.
+				if iter.trySniffCandidate(ctx, event.Name, iter.pendingCandidates[event.Name]) {
+					return nil
+				}
+			}
+		}
+	}
+}
+
+// trySniffCandidate attempts to sniff a parser for a rotated file that
+// was just created or written to. On success it removes name from
+// iter.pendingCandidates (if present) and appends the file to
+// iter.pending, returning true. On failure (nothing parseable yet) it
+// records the file in iter.pendingCandidates so a later Write event on
+// the same name retries the sniff, and returns false without closing f.
+func (iter *logIterator) trySniffCandidate(ctx context.Context, name string, f *os.File) bool {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		delete(iter.pendingCandidates, name)
+		_ = f.Close()
+		return false
+	}
+	parser, _, err := SniffFile(ctx, bufio.NewReader(f), 10)
+	if err != nil {
+		if iter.pendingCandidates == nil {
+			iter.pendingCandidates = make(map[string]*os.File)
+		}
+		iter.pendingCandidates[name] = f
+		return false
+	}
+	delete(iter.pendingCandidates, name)
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		_ = f.Close()
+		return false
+	}
+	iter.pending = append(iter.pending, logFile{file: f, end: math.MaxInt64, parser: parser})
+	return true
+}
+
+// resumeAfterWrite re-seeks cur.file to the start if it was truncated
+// since iter last read from it, so the next read doesn't keep observing
+// a stale io.EOF at the old size.
+func (iter *logIterator) resumeAfterWrite(cur *logFile) {
+	stat, err := cur.file.Stat()
+	if err != nil {
+		return
+	}
+	pos, err := cur.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return
+	}
+	if pos > stat.Size() {
+		if _, err := cur.file.Seek(0, io.SeekStart); err == nil {
+			iter.reader = bufio.NewReader(cur.file)
+		}
+	}
+}
+
+// isOwnRotatedFile reports whether name is a newly-created rotated log
+// file belonging to this search, using the same prefix+ext matching rule
+// as resolveFiles.
+func (iter *logIterator) isOwnRotatedFile(name string) bool {
+	base := filepath.Base(name)
+	prefixBase := filepath.Base(iter.filePrefix)
+	if !strings.HasPrefix(base, prefixBase) {
+		return false
+	}
+	if c, ok := codecForPath(base); ok {
+		base = base[:len(base)-len(c.Suffix())]
+	}
+	return strings.HasSuffix(base, iter.ext)
+}
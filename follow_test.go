@@ -0,0 +1,97 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sysutil
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestWaitForMoreAdoptsFileCreatedEmptyThenWritten reproduces a rotator
+// that creates the next log file empty and only writes a valid line into
+// it moments later: the Create event alone has nothing to sniff, and
+// waitForMore must retry on the file's own later Write event instead of
+// losing track of it.
+func TestWaitForMoreAdoptsFileCreatedEmptyThenWritten(t *testing.T) {
+	dir := t.TempDir()
+	activeName := filepath.Join(dir, "tidb.0.log")
+	if err := os.WriteFile(activeName, []byte("[2019/08/26 06:19:13.011 -04:00] [INFO] [printer.go:41] [\"first\"]\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	active, err := os.Open(activeName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer active.Close()
+
+	iter := &logIterator{
+		pending:           []logFile{{file: active, parser: bracketLogParser{}}},
+		logDir:            dir,
+		filePrefix:        filepath.Join(dir, "tidb"),
+		ext:               ".log",
+		Follow:            true,
+		FollowIdleTimeout: 3 * time.Second,
+	}
+	defer iter.close()
+
+	rotatedName := filepath.Join(dir, "tidb.1.log")
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		f, err := os.Create(rotatedName)
+		if err != nil {
+			return
+		}
+		_ = f.Close()
+
+		time.Sleep(300 * time.Millisecond)
+		f, err = os.OpenFile(rotatedName, os.O_WRONLY, 0o644)
+		if err != nil {
+			return
+		}
+		defer f.Close()
+		_, _ = f.WriteString("[2019/08/26 06:19:14.011 -04:00] [INFO] [printer.go:41] [\"second\"]\n")
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := iter.waitForMore(ctx); err != nil {
+		t.Fatalf("waitForMore: %v", err)
+	}
+	if len(iter.pending) != 2 {
+		t.Fatalf("len(iter.pending) = %d, want 2 (the rotated file should have been adopted)", len(iter.pending))
+	}
+	if _, stillPending := iter.pendingCandidates[rotatedName]; stillPending {
+		t.Errorf("rotated file is still tracked as a pending candidate after being adopted")
+	}
+}
+
+func TestIsOwnRotatedFile(t *testing.T) {
+	iter := &logIterator{filePrefix: "/var/log/tidb", ext: ".log"}
+	cases := map[string]bool{
+		"/var/log/tidb.1.log":     true,
+		"/var/log/tidb.1.log.gz":  true,
+		"/var/log/tidb.1.log.zst": true,
+		"/var/log/other.log":      false,
+		"/var/log/tidb.1.txt":     false,
+	}
+	for name, want := range cases {
+		if got := iter.isOwnRotatedFile(name); got != want {
+			t.Errorf("isOwnRotatedFile(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
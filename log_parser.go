@@ -0,0 +1,217 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sysutil
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	pb "github.com/pingcap/kvproto/pkg/diagnosticspb"
+)
+
+// LogParser turns one raw log line into a structured LogMessage. A log
+// directory can mix files in different formats; resolveFiles sniffs
+// each candidate file's first valid line with SniffFile to pick the
+// LogParser that should read the rest of it.
+type LogParser interface {
+	ParseLine(line string) (*pb.LogMessage, error)
+}
+
+// registeredLogParsers holds every LogParser SniffFile tries, in
+// registration order. The bracket format is tried first since it's the
+// original and most common TiDB / TiKV / PD format.
+var registeredLogParsers = []LogParser{
+	bracketLogParser{},
+}
+
+// RegisterLogParser adds parser to the set SniffFile tries, after every
+// previously registered parser.
+func RegisterLogParser(parser LogParser) {
+	registeredLogParsers = append(registeredLogParsers, parser)
+}
+
+// SniffFile tries each registered LogParser against up to tryLines lines
+// read from reader, returning the first parser to successfully parse a
+// line along with that line's parsed result.
+func SniffFile(ctx context.Context, reader *bufio.Reader, tryLines int64) (LogParser, *pb.LogMessage, error) {
+	var tried int64
+	for {
+		line, err := readLine(reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, parser := range registeredLogParsers {
+			if item, err := parser.ParseLine(line); err == nil {
+				return parser, item, nil
+			}
+		}
+		tried++
+		if tried >= tryLines {
+			break
+		}
+		if isCtxDone(ctx) {
+			return nil, nil, ctx.Err()
+		}
+	}
+	return nil, nil, errors.New("no registered log parser matched this file")
+}
+
+// bracketLogParser parses the original TiDB / TiKV / PD unified log
+// format:
+//
+// [2019/08/26 06:19:13.011 -04:00] [INFO] [printer.go:41] ["Welcome to TiDB."] ["Release Version"=v2.1.14]...
+// [2019/08/26 07:19:49.529 -04:00] [INFO] [printer.go:41] ["Welcome to TiDB."] ["Release Version"=v3.0.2]...
+// [2019/08/21 01:43:01.460 -04:00] [INFO] [util.go:60] [PD] [release-version=v3.0.2]
+// [2019/08/26 07:20:23.815 -04:00] [INFO] [mod.rs:28] ["Release Version:   3.0.2"]
+type bracketLogParser struct{}
+
+func (bracketLogParser) ParseLine(s string) (*pb.LogMessage, error) {
+	timeLeftBound := strings.Index(s, "[")
+	timeRightBound := strings.Index(s, "]")
+	if timeLeftBound == -1 || timeRightBound == -1 || timeLeftBound > timeRightBound {
+		return nil, fmt.Errorf("invalid log string: %s", s)
+	}
+	time, err := parseTimeStamp(s[timeLeftBound+1 : timeRightBound])
+	if err != nil {
+		return nil, err
+	}
+	levelLeftBound := strings.Index(s[timeRightBound+1:], "[")
+	levelRightBound := strings.Index(s[timeRightBound+1:], "]")
+	if levelLeftBound == -1 || levelRightBound == -1 || levelLeftBound > levelRightBound {
+		return nil, fmt.Errorf("invalid log string: %s", s)
+	}
+	level := ParseLogLevel(s[timeRightBound+1+levelLeftBound+1 : timeRightBound+1+levelRightBound])
+	return &pb.LogMessage{
+		Time:    time,
+		Level:   level,
+		Message: strings.TrimSpace(s[timeRightBound+levelRightBound+2:]),
+	}, nil
+}
+
+// JSONLogParser parses docker jsonfile-style logs, one JSON object per
+// line, e.g. {"time":"2019-08-26T06:19:13.011-04:00","level":"info","msg":"..."}.
+// The field names are configurable since different JSON log shippers
+// disagree on them; use NewJSONLogParser to build one for a non-default
+// schema and RegisterLogParser it.
+type JSONLogParser struct {
+	TimeField  string
+	LevelField string
+	MsgField   string
+	// TimeLayout is the time.Parse layout used for TimeField. An empty
+	// TimeLayout means TimeField already holds a unix-ms integer.
+	TimeLayout string
+}
+
+// NewJSONLogParser returns a JSONLogParser for the given field names and
+// time layout. An empty timeLayout means timeField holds a unix-ms
+// integer rather than a formatted string.
+func NewJSONLogParser(timeField, levelField, msgField, timeLayout string) JSONLogParser {
+	return JSONLogParser{
+		TimeField:  timeField,
+		LevelField: levelField,
+		MsgField:   msgField,
+		TimeLayout: timeLayout,
+	}
+}
+
+// defaultJSONLogParser is registered by init for the common
+// "time"/"level"/"msg" field names with RFC3339 timestamps.
+func defaultJSONLogParser() JSONLogParser {
+	return NewJSONLogParser("time", "level", "msg", time.RFC3339Nano)
+}
+
+func (p JSONLogParser) ParseLine(s string) (*pb.LogMessage, error) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal([]byte(s), &raw); err != nil {
+		return nil, fmt.Errorf("invalid json log line: %w", err)
+	}
+	tsRaw, ok := raw[p.TimeField]
+	if !ok {
+		return nil, fmt.Errorf("json log line missing %q field", p.TimeField)
+	}
+	var ts int64
+	switch v := tsRaw.(type) {
+	case string:
+		t, err := time.Parse(p.TimeLayout, v)
+		if err != nil {
+			return nil, err
+		}
+		ts = t.UnixNano() / int64(time.Millisecond)
+	case float64:
+		ts = int64(v)
+	default:
+		return nil, fmt.Errorf("unsupported json time field type %T", v)
+	}
+	msg, _ := raw[p.MsgField].(string)
+	return &pb.LogMessage{
+		Time:    ts,
+		Level:   ParseLogLevel(fmt.Sprint(raw[p.LevelField])),
+		Message: msg,
+	}, nil
+}
+
+// klogLinePattern matches Kubernetes klog lines, e.g.:
+// I0826 06:19:13.011000       1 printer.go:41] Welcome to TiDB.
+var klogLinePattern = regexp.MustCompile(`^([IWEF])(\d{2})(\d{2}) (\d{2}):(\d{2}):(\d{2})\.(\d{6})\s+\d+ (\S+)\] (.*)$`)
+
+// KlogLogParser parses Kubernetes klog-formatted lines. klog doesn't
+// record a year, so the current year is assumed.
+type KlogLogParser struct{}
+
+func (KlogLogParser) ParseLine(s string) (*pb.LogMessage, error) {
+	m := klogLinePattern.FindStringSubmatch(s)
+	if m == nil {
+		return nil, fmt.Errorf("invalid klog line: %s", s)
+	}
+	month, _ := strconv.Atoi(m[2])
+	day, _ := strconv.Atoi(m[3])
+	hour, _ := strconv.Atoi(m[4])
+	minute, _ := strconv.Atoi(m[5])
+	second, _ := strconv.Atoi(m[6])
+	micros, _ := strconv.Atoi(m[7])
+	t := time.Date(time.Now().Year(), time.Month(month), day, hour, minute, second, micros*int(time.Microsecond), time.Local)
+	return &pb.LogMessage{
+		Time:    t.UnixNano() / int64(time.Millisecond),
+		Level:   klogLevel(m[1]),
+		Message: m[8] + "] " + m[9],
+	}, nil
+}
+
+func klogLevel(letter string) pb.LogLevel {
+	switch letter {
+	case "I":
+		return pb.LogLevel_Info
+	case "W":
+		return pb.LogLevel_Warn
+	case "E":
+		return pb.LogLevel_Error
+	case "F":
+		return pb.LogLevel_Critical
+	default:
+		return pb.LogLevel_UNKNOWN
+	}
+}
+
+func init() {
+	RegisterLogParser(defaultJSONLogParser())
+	RegisterLogParser(KlogLogParser{})
+}
@@ -0,0 +1,106 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sysutil
+
+import (
+	"bufio"
+	"context"
+	"strings"
+	"testing"
+
+	pb "github.com/pingcap/kvproto/pkg/diagnosticspb"
+)
+
+func TestBracketLogParser(t *testing.T) {
+	item, err := bracketLogParser{}.ParseLine(`[2019/08/26 06:19:13.011 -04:00] [INFO] [printer.go:41] ["Welcome to TiDB."]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantTime, err := parseTimeStamp("2019/08/26 06:19:13.011 -04:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Time != wantTime {
+		t.Errorf("Time = %d, want %d", item.Time, wantTime)
+	}
+	if item.Level != pb.LogLevel_Info {
+		t.Errorf("Level = %v, want Info", item.Level)
+	}
+}
+
+func TestDefaultJSONLogParser(t *testing.T) {
+	item, err := defaultJSONLogParser().ParseLine(`{"time":"2019-08-26T06:19:13.011-04:00","level":"info","msg":"hello"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Message != "hello" {
+		t.Errorf("Message = %q, want %q", item.Message, "hello")
+	}
+	if item.Level != pb.LogLevel_Info {
+		t.Errorf("Level = %v, want Info", item.Level)
+	}
+}
+
+// TestNewJSONLogParserCustomFields confirms JSONLogParser is actually
+// usable with a non-default schema, e.g. a shipper that uses "ts"/"lvl"
+// and unix-ms timestamps instead of "time"/"level".
+func TestNewJSONLogParserCustomFields(t *testing.T) {
+	p := NewJSONLogParser("ts", "lvl", "msg", "")
+	item, err := p.ParseLine(`{"ts":1566814753011,"lvl":"warn","msg":"custom"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Time != 1566814753011 {
+		t.Errorf("Time = %d, want 1566814753011", item.Time)
+	}
+	if item.Level != pb.LogLevel_Warn {
+		t.Errorf("Level = %v, want Warn", item.Level)
+	}
+	if item.Message != "custom" {
+		t.Errorf("Message = %q, want %q", item.Message, "custom")
+	}
+}
+
+func TestKlogLogParser(t *testing.T) {
+	item, err := (KlogLogParser{}).ParseLine(`I0826 06:19:13.011000       1 printer.go:41] Welcome to TiDB.`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Level != pb.LogLevel_Info {
+		t.Errorf("Level = %v, want Info", item.Level)
+	}
+	if item.Message != "printer.go:41] Welcome to TiDB." {
+		t.Errorf("Message = %q, want %q", item.Message, "printer.go:41] Welcome to TiDB.")
+	}
+}
+
+func TestSniffFilePicksRegisteredParser(t *testing.T) {
+	cases := []struct {
+		name string
+		line string
+	}{
+		{"bracket", `[2019/08/26 06:19:13.011 -04:00] [INFO] [printer.go:41] ["Welcome to TiDB."]`},
+		{"json", `{"time":"2019-08-26T06:19:13.011-04:00","level":"info","msg":"hello"}`},
+		{"klog", `I0826 06:19:13.011000       1 printer.go:41] Welcome to TiDB.`},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			parser, _, err := SniffFile(context.Background(), bufio.NewReader(strings.NewReader(c.line+"\n")), 1)
+			if err != nil || parser == nil {
+				t.Errorf("SniffFile picked no registered parser for %q: %v", c.line, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sysutil
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"golang.org/x/sync/errgroup"
+
+	pb "github.com/pingcap/kvproto/pkg/diagnosticspb"
+)
+
+// resolveFiles finds every rotated log file sharing logFilePath's prefix
+// and extension whose time range overlaps [beginTime, endTime]. Probing
+// each candidate (open, sniff its parser, read its first and last valid
+// lines) is dispatched to a worker pool sized by concurrency (or
+// runtime.GOMAXPROCS(0) when concurrency <= 0), since on a node with
+// hundreds of rotated files this probing is the dominant cost of a
+// diagnostics search.
+func resolveFiles(ctx context.Context, logFilePath string, beginTime, endTime int64, concurrency int) ([]logFile, error) {
+	if logFilePath == "" {
+		return nil, errors.New("empty log file location configuration")
+	}
+	if concurrency <= 0 {
+		concurrency = runtime.GOMAXPROCS(0)
+	}
+
+	logDir := filepath.Dir(logFilePath)
+	ext := filepath.Ext(logFilePath)
+	filePrefix := logFilePath[:len(logFilePath)-len(ext)]
+	entries, err := os.ReadDir(logDir)
+	if err != nil {
+		return nil, err
+	}
+
+	g, gctx := errgroup.WithContext(ctx)
+	sem := make(chan struct{}, concurrency)
+	results := make(chan *logFile, len(entries))
+
+	for _, entry := range entries {
+		entry := entry
+		g.Go(func() error {
+			select {
+			case sem <- struct{}{}:
+			case <-gctx.Done():
+				return gctx.Err()
+			}
+			defer func() { <-sem }()
+
+			path := filepath.Join(logDir, entry.Name())
+			lf, skip, err := probeLogFile(gctx, path, entry, filePrefix, ext, beginTime, endTime)
+			if skip != nil {
+				_ = skip.Close()
+			}
+			if err != nil {
+				return err
+			}
+			results <- lf
+			return nil
+		})
+	}
+
+	go func() {
+		_ = g.Wait()
+		close(results)
+	}()
+
+	var logFiles []logFile
+	for lf := range results {
+		if lf != nil {
+			logFiles = append(logFiles, *lf)
+		}
+	}
+	if err := g.Wait(); err != nil {
+		for _, lf := range logFiles {
+			_ = lf.file.Close()
+		}
+		return nil, err
+	}
+
+	// Sort by start time
+	sort.Slice(logFiles, func(i, j int) bool {
+		return logFiles[i].begin < logFiles[j].begin
+	})
+
+	// Assume no time range overlap in log files and remove unnecessary log files for compressed files.
+	// When logFiles[i-1].end < begin < logFiles[i].begin, it will return one more logFiles[i-1].
+	idx := 0
+	for i := range logFiles {
+		if i == 0 {
+			continue
+		}
+		if logFiles[i].begin < beginTime {
+			idx = i
+			_ = logFiles[i-1].file.Close()
+		} else {
+			break
+		}
+	}
+	return logFiles[idx:], nil
+}
+
+// probeLogFile decides whether the single candidate at path belongs in
+// the result set. It returns at most one non-nil value: a *logFile to
+// keep, or a *os.File to close because it was opened but doesn't
+// qualify. A non-nil error means probing was aborted (e.g. by context
+// cancellation) rather than that the file was merely skipped.
+func probeLogFile(ctx context.Context, path string, info os.DirEntry, filePrefix, ext string, beginTime, endTime int64) (*logFile, *os.File, error) {
+	if info.IsDir() {
+		return nil, nil, nil
+	}
+	// All rotated log files have the same prefix and extension with the original file
+	if !strings.HasPrefix(path, filePrefix) {
+		return nil, nil, nil
+	}
+	var codec CompressionCodec
+	rawPath := path
+	if c, ok := codecForPath(path); ok {
+		codec = c
+		rawPath = path[0 : len(path)-len(c.Suffix())]
+	}
+	if !strings.HasSuffix(rawPath, ext) {
+		return nil, nil, nil
+	}
+	if isCtxDone(ctx) {
+		return nil, nil, ctx.Err()
+	}
+
+	// If we cannot open the file, we skip to search the file instead of returning
+	// error and abort entire searching task.
+	// TODO: do we need to return some warning to client?
+	var file *os.File
+	var err error
+	if codec == nil {
+		file, err = os.OpenFile(rawPath, os.O_RDONLY, os.ModePerm)
+	} else {
+		file, err = os.OpenFile(rawPath+codec.Suffix(), os.O_RDONLY, os.ModePerm)
+	}
+	if err != nil {
+		return nil, nil, nil
+	}
+
+	var firstItemTime, lastItemTime int64
+	var parser LogParser
+	if codec == nil {
+		var firstItem *pb.LogMessage
+		parser, firstItem, err = SniffFile(ctx, bufio.NewReader(file), 10)
+		if err != nil {
+			if isCtxDone(ctx) {
+				return nil, file, ctx.Err()
+			}
+			return nil, file, nil
+		}
+		firstItemTime = firstItem.Time
+
+		lastItem, err := readLastValidLog(ctx, file, 10, parser)
+		if err != nil {
+			if isCtxDone(ctx) {
+				return nil, file, ctx.Err()
+			}
+			return nil, file, nil
+		}
+		lastItemTime = lastItem.Time
+
+		// Reset position to the start and skip this file if cannot seek to start
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return nil, file, nil
+		}
+	} else {
+		firstItemTime, lastItemTime, parser, err = timeRangeForCompressed(ctx, file, codec)
+		if err != nil {
+			if isCtxDone(ctx) {
+				return nil, file, ctx.Err()
+			}
+			return nil, file, nil
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return nil, file, nil
+		}
+	}
+
+	if beginTime > lastItemTime || endTime < firstItemTime {
+		return nil, file, nil
+	}
+	return &logFile{
+		file:   file,
+		begin:  firstItemTime,
+		end:    lastItemTime,
+		codec:  codec,
+		parser: parser,
+	}, nil, nil
+}
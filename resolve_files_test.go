@@ -0,0 +1,85 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sysutil
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// benchResolveFilesDir lays out n rotated log files (half plain, half
+// gzipped) under a fresh temp directory, mimicking a node that's been
+// rotating logs for a while, and returns the logFilePath to pass to
+// resolveFiles.
+func benchResolveFilesDir(b *testing.B, n int) string {
+	b.Helper()
+	dir := b.TempDir()
+	base := filepath.Join(dir, "tidb.log")
+	start, err := parseTimeStamp("2019/08/26 00:00:00.000 -04:00")
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		ms := start + int64(i)*1000
+		t := time.Unix(0, ms*int64(time.Millisecond)).In(time.FixedZone("", -4*60*60))
+		line := fmt.Sprintf("[%s] [INFO] [printer.go:41] [\"rotated log %d\"]\n", t.Format(TimeStampLayout), i)
+		name := fmt.Sprintf("%s.%d.log", base[:len(base)-len(".log")], i)
+		if err := os.WriteFile(name, []byte(line), 0o644); err != nil {
+			b.Fatal(err)
+		}
+		if i%2 == 0 {
+			gz := name + ".gz"
+			if err := CompressLogFile(name, gz); err != nil {
+				b.Fatal(err)
+			}
+			if err := os.Remove(name); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	return base
+}
+
+// BenchmarkResolveFilesConcurrency compares resolveFiles' wall-clock cost
+// across a directory of 200 mixed plain/gz rotated files at concurrency
+// 1 (serial) versus GOMAXPROCS, showing the speedup from probing
+// candidates in parallel.
+func BenchmarkResolveFilesConcurrency(b *testing.B) {
+	const numFiles = 200
+	base := benchResolveFilesDir(b, numFiles)
+
+	for _, concurrency := range []int{1, 0} {
+		concurrency := concurrency
+		name := "Serial"
+		if concurrency == 0 {
+			name = "GOMAXPROCS"
+		}
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				logFiles, err := resolveFiles(context.Background(), base, 0, int64(1)<<62, concurrency)
+				if err != nil {
+					b.Fatal(err)
+				}
+				for _, lf := range logFiles {
+					_ = lf.file.Close()
+				}
+			}
+		})
+	}
+}
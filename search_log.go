@@ -16,26 +16,23 @@ package sysutil
 
 import (
 	"bufio"
-	"compress/gzip"
 	"context"
 	"errors"
-	"fmt"
 	"io"
-	"math"
 	"os"
-	"path/filepath"
 	"regexp"
-	"sort"
 	"strings"
 	"time"
 
+	"github.com/fsnotify/fsnotify"
 	pb "github.com/pingcap/kvproto/pkg/diagnosticspb"
 )
 
 type logFile struct {
-	file       *os.File // The opened file handle
-	begin, end int64    // The timesteamp in millisecond of first line
-	compressed bool     // The file is compressed or not
+	file       *os.File         // The opened file handle
+	begin, end int64            // The timesteamp in millisecond of first line
+	codec      CompressionCodec // The codec used to decompress the file, nil if the file is plain text
+	parser     LogParser        // The parser sniffed for this file's lines
 }
 
 func (l *logFile) BeginTime() int64 {
@@ -46,137 +43,10 @@ func (l *logFile) EndTime() int64 {
 	return l.end
 }
 
+// compressSuffix is the suffix owned by the gzip codec; files are
+// matched to codecs through codecForPath.
 const compressSuffix = ".gz"
 
-func resolveFiles(ctx context.Context, logFilePath string, beginTime, endTime int64) ([]logFile, error) {
-	if logFilePath == "" {
-		return nil, errors.New("empty log file location configuration")
-	}
-
-	var logFiles []logFile
-	var skipFiles []*os.File
-	logDir := filepath.Dir(logFilePath)
-	ext := filepath.Ext(logFilePath)
-	filePrefix := logFilePath[:len(logFilePath)-len(ext)]
-	files, err := os.ReadDir(logDir)
-	if err != nil {
-		return nil, err
-	}
-	walkFn := func(path string, info os.DirEntry) error {
-		if info.IsDir() {
-			return nil
-		}
-		// All rotated log files have the same prefix and extension with the original file
-		if !strings.HasPrefix(path, filePrefix) {
-			return nil
-		}
-		compressed := false
-		if strings.HasSuffix(path, compressSuffix) {
-			compressed = true
-			path = path[0 : len(path)-len(compressSuffix)]
-		}
-		if !strings.HasSuffix(path, ext) {
-			return nil
-		}
-		if isCtxDone(ctx) {
-			return ctx.Err()
-		}
-		// If we cannot open the file, we skip to search the file instead of returning
-		// error and abort entire searching task.
-		// TODO: do we need to return some warning to client?
-		var file *os.File
-		if !compressed {
-			file, err = os.OpenFile(path, os.O_RDONLY, os.ModePerm)
-		} else {
-			file, err = os.OpenFile(path+compressSuffix, os.O_RDONLY, os.ModePerm)
-		}
-		if err != nil {
-			return nil
-		}
-		var reader *bufio.Reader
-		if !compressed {
-			reader = bufio.NewReader(file)
-		} else {
-			gr, err := gzip.NewReader(file)
-			if err != nil {
-				return nil
-			}
-			reader = bufio.NewReader(gr)
-		}
-
-		var firstItemTime, lastItemTime int64
-		firstItem, err := readFirstValidLog(ctx, reader, 10)
-		if err != nil {
-			skipFiles = append(skipFiles, file)
-			return nil
-		}
-		firstItemTime = firstItem.Time
-
-		if !compressed {
-			lastItem, err := readLastValidLog(ctx, file, 10)
-			if err != nil {
-				skipFiles = append(skipFiles, file)
-				return nil
-			}
-			lastItemTime = lastItem.Time
-		} else {
-			// For compressed file, it's hard to get last item,
-			// and to avoid decompression, we assume lastTime equals to `math.MaxInt64`.
-			lastItemTime = math.MaxInt64
-		}
-		// Reset position to the start and skip this file if cannot seek to start
-		if _, err := file.Seek(0, io.SeekStart); err != nil {
-			skipFiles = append(skipFiles, file)
-			return nil
-		}
-
-		if beginTime > lastItemTime || endTime < firstItemTime {
-			skipFiles = append(skipFiles, file)
-		} else {
-			logFiles = append(logFiles, logFile{
-				file:       file,
-				begin:      firstItemTime,
-				end:        lastItemTime,
-				compressed: compressed,
-			})
-		}
-		return nil
-	}
-	for _, file := range files {
-		err := walkFn(filepath.Join(logDir, file.Name()), file)
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	defer func() {
-		for _, f := range skipFiles {
-			_ = f.Close()
-		}
-	}()
-
-	// Sort by start time
-	sort.Slice(logFiles, func(i, j int) bool {
-		return logFiles[i].begin < logFiles[j].begin
-	})
-
-	// Assume no time range overlap in log files and remove unnecessary log files for compressed files.
-	// When logFiles[i-1].end < begin < logFiles[i].begin, it will return one more logFiles[i-1].
-	idx := 0
-	for i := range logFiles {
-		if i == 0 {
-			continue
-		}
-		if logFiles[i].begin < beginTime {
-			idx = i
-			skipFiles = append(skipFiles, logFiles[i-1].file)
-		} else {
-			break
-		}
-	}
-	return logFiles[idx:], err
-}
-
 func isCtxDone(ctx context.Context) bool {
 	select {
 	case <-ctx.Done():
@@ -186,14 +56,14 @@ func isCtxDone(ctx context.Context) bool {
 	}
 }
 
-func readFirstValidLog(ctx context.Context, reader *bufio.Reader, tryLines int64) (*pb.LogMessage, error) {
+func readFirstValidLog(ctx context.Context, reader *bufio.Reader, tryLines int64, parser LogParser) (*pb.LogMessage, error) {
 	var tried int64
 	for {
 		line, err := readLine(reader)
 		if err != nil {
 			return nil, err
 		}
-		item, err := parseLogItem(line)
+		item, err := parser.ParseLine(line)
 		if err == nil {
 			return item, nil
 		}
@@ -208,7 +78,7 @@ func readFirstValidLog(ctx context.Context, reader *bufio.Reader, tryLines int64
 	return nil, errors.New("not a valid log file")
 }
 
-func readLastValidLog(ctx context.Context, file *os.File, tryLines int) (*pb.LogMessage, error) {
+func readLastValidLog(ctx context.Context, file *os.File, tryLines int, parser LogParser) (*pb.LogMessage, error) {
 	var tried int
 	stat, _ := file.Stat()
 	endCursor := stat.Size()
@@ -223,7 +93,7 @@ func readLastValidLog(ctx context.Context, file *os.File, tryLines int) (*pb.Log
 		}
 		endCursor -= int64(readBytes)
 		for i := len(lines) - 1; i >= 0; i-- {
-			item, err := parseLogItem(lines[i])
+			item, err := parser.ParseLine(lines[i])
 			if err == nil {
 				return item, nil
 			}
@@ -332,39 +202,6 @@ func ParseLogLevel(s string) pb.LogLevel {
 	}
 }
 
-// parses single log line and returns:
-// 1. the timesteamp in unix milliseconds
-// 2. the log level
-// 3. the log item content
-//
-// [2019/08/26 06:19:13.011 -04:00] [INFO] [printer.go:41] ["Welcome to TiDB."] ["Release Version"=v2.1.14]...
-// [2019/08/26 07:19:49.529 -04:00] [INFO] [printer.go:41] ["Welcome to TiDB."] ["Release Version"=v3.0.2]...
-// [2019/08/21 01:43:01.460 -04:00] [INFO] [util.go:60] [PD] [release-version=v3.0.2]
-// [2019/08/26 07:20:23.815 -04:00] [INFO] [mod.rs:28] ["Release Version:   3.0.2"]
-func parseLogItem(s string) (*pb.LogMessage, error) {
-	timeLeftBound := strings.Index(s, "[")
-	timeRightBound := strings.Index(s, "]")
-	if timeLeftBound == -1 || timeRightBound == -1 || timeLeftBound > timeRightBound {
-		return nil, fmt.Errorf("invalid log string: %s", s)
-	}
-	time, err := parseTimeStamp(s[timeLeftBound+1 : timeRightBound])
-	if err != nil {
-		return nil, err
-	}
-	levelLeftBound := strings.Index(s[timeRightBound+1:], "[")
-	levelRightBound := strings.Index(s[timeRightBound+1:], "]")
-	if levelLeftBound == -1 || levelRightBound == -1 || levelLeftBound > levelRightBound {
-		return nil, fmt.Errorf("invalid log string: %s", s)
-	}
-	level := ParseLogLevel(s[timeRightBound+1+levelLeftBound+1 : timeRightBound+1+levelRightBound])
-	item := &pb.LogMessage{
-		Time:    time,
-		Level:   level,
-		Message: strings.TrimSpace(s[timeRightBound+levelRightBound+2:]),
-	}
-	return item, nil
-}
-
 const (
 	// TimeStampLayout is accessed in dashboard, keep it public
 	TimeStampLayout    = "2006/01/02 15:04:05.000 -07:00"
@@ -396,25 +233,99 @@ type logIterator struct {
 	reader    *bufio.Reader
 	pending   []logFile
 	preLog    *pb.LogMessage
+
+	// Follow, if set, makes next() block for new data on the last
+	// pending file instead of returning io.EOF: new writes to the active
+	// log file are streamed as they happen, and new rotated files
+	// matching filePrefix+ext are picked up automatically.
+	Follow bool
+	// FollowIdleTimeout ends the follow, returning io.EOF, after this
+	// long with no new bytes. Zero means follow forever.
+	FollowIdleTimeout time.Duration
+
+	// logDir, filePrefix and ext identify which new files in logDir
+	// belong to this search, mirroring resolveFiles' own matching rule.
+	logDir     string
+	filePrefix string
+	ext        string
+	watcher    *fsnotify.Watcher
+
+	// pendingCandidates holds rotated files (keyed by path) that were
+	// seen via a fsnotify Create event but didn't yet have anything
+	// parseable in them; waitForMore retries sniffing them on their own
+	// later Write events instead of losing track of them.
+	pendingCandidates map[string]*os.File
+
+	// decoder is the io.ReadCloser a compressed file's CompressionCodec
+	// handed back for the current reader, if any, so it can be closed
+	// once iter moves past it.
+	decoder io.ReadCloser
 }
 
 // The Close method close all resources the iterator has.
 func (iter *logIterator) close() {
+	if iter.watcher != nil {
+		_ = iter.watcher.Close()
+	}
+	if iter.decoder != nil {
+		_ = iter.decoder.Close()
+	}
 	for _, f := range iter.pending {
 		_ = f.file.Close()
 	}
+	for _, f := range iter.pendingCandidates {
+		_ = f.Close()
+	}
 }
 
-func (iter *logIterator) updateToNextReader() error {
-	if !iter.pending[iter.fileIndex].compressed {
-		iter.reader = bufio.NewReader(iter.pending[iter.fileIndex].file)
-	} else {
-		gr, err := gzip.NewReader(iter.pending[iter.fileIndex].file)
+func (iter *logIterator) updateToNextReader(ctx context.Context) error {
+	if iter.decoder != nil {
+		_ = iter.decoder.Close()
+		iter.decoder = nil
+	}
+	cur := &iter.pending[iter.fileIndex]
+	if cur.codec == nil {
+		if cur.begin < iter.begin && cur.parser != nil {
+			if offset, err := seekToTime(cur.file, iter.begin, cur.parser); err == nil {
+				if _, err := cur.file.Seek(offset, io.SeekStart); err != nil {
+					return err
+				}
+			}
+		}
+		iter.reader = bufio.NewReader(cur.file)
+		return nil
+	}
+	stat, err := cur.file.Stat()
+	if err != nil {
+		return err
+	}
+	if cur.parser == nil {
+		// resolveFiles bounded this file's time range from an embedded
+		// index/header without decompressing it, so its parser is still
+		// unknown. Sniff it from a throwaway decode; the real reader
+		// below starts decoding fresh so nothing is lost.
+		probe, err := cur.codec.NewReader(cur.file, stat.Size())
 		if err != nil {
 			return err
 		}
-		iter.reader = bufio.NewReader(gr)
+		parser, _, err := SniffFile(ctx, bufio.NewReader(probe), 10)
+		_ = probe.Close()
+		if err != nil {
+			return err
+		}
+		cur.parser = parser
 	}
+	var rc io.ReadCloser
+	if sc, ok := cur.codec.(SeekableCodec); ok {
+		rc, err = sc.SeekToOffset(cur.file, stat.Size(), iter.begin, iter.end)
+	} else {
+		rc, err = cur.codec.NewReader(cur.file, stat.Size())
+	}
+	if err != nil {
+		return err
+	}
+	iter.decoder = rc
+	iter.reader = bufio.NewReader(rc)
 	return nil
 }
 
@@ -424,7 +335,7 @@ func (iter *logIterator) next(ctx context.Context) (*pb.LogMessage, error) {
 		if len(iter.pending) == 0 {
 			return nil, io.EOF
 		}
-		if err := iter.updateToNextReader(); err != nil {
+		if err := iter.updateToNextReader(ctx); err != nil {
 			return nil, err
 		}
 	}
@@ -437,11 +348,17 @@ nextLine:
 		line, err := readLine(iter.reader)
 		// Switch to next log file
 		if err != nil && err == io.EOF {
+			if iter.Follow && iter.fileIndex == len(iter.pending)-1 {
+				if err := iter.waitForMore(ctx); err != nil {
+					return nil, err
+				}
+				continue
+			}
 			iter.fileIndex++
 			if iter.fileIndex >= len(iter.pending) {
 				return nil, io.EOF
 			}
-			if err := iter.updateToNextReader(); err != nil {
+			if err := iter.updateToNextReader(ctx); err != nil {
 				return nil, err
 			}
 			continue
@@ -450,7 +367,7 @@ nextLine:
 		if iter.preLog == nil && len(line) < timeStampLayoutLen {
 			continue
 		}
-		item, err := parseLogItem(line)
+		item, err := iter.pending[iter.fileIndex].parser.ParseLine(line)
 		if err != nil {
 			if iter.preLog == nil {
 				continue
@@ -0,0 +1,156 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sysutil
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+
+	pb "github.com/pingcap/kvproto/pkg/diagnosticspb"
+)
+
+// seekLinearScanThreshold is how small the binary search range in
+// seekToTime must shrink to before it falls back to a linear scan.
+const seekLinearScanThreshold = 64 * 1024
+
+// seekMaxSkipTries bounds how many unparseable lines firstParseableLineAt
+// will skip over while looking for one to compare against, so a long run
+// of corrupt lines can't turn a probe into an unbounded scan.
+const seekMaxSkipTries = 20
+
+// seekToTime binary-searches file for the byte offset of the first line
+// whose parsed timestamp is >= begin, so a large uncompressed log can
+// start being read near begin instead of linearly discarding every
+// earlier line. Lines that fail to parse are skipped over rather than
+// treated as an error. file's read/write offset is left untouched;
+// callers should Seek file to the returned offset themselves.
+func seekToTime(file *os.File, begin int64, parser LogParser) (int64, error) {
+	stat, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	lo, hi := int64(0), stat.Size()
+	for hi-lo > seekLinearScanThreshold {
+		mid := lo + (hi-lo)/2
+		lineStart, item, ok := firstParseableLineAt(file, mid, hi, parser)
+		if !ok {
+			// Nothing parseable between mid and hi; whatever we're
+			// looking for must be in the first half.
+			hi = mid
+			continue
+		}
+		if item.Time >= begin {
+			hi = lineStart
+		} else if next, ok := nextLineStart(file, lineStart+1, hi); ok {
+			lo = next
+		} else {
+			lo = hi
+		}
+	}
+	return linearScanForTime(file, lo, hi, begin, parser)
+}
+
+// linearScanForTime scans lines in [lo, hi) in order, returning the
+// offset of the first one parser accepts with a timestamp >= begin, or
+// hi if none qualifies (including when every remaining line is
+// unparseable).
+func linearScanForTime(file *os.File, lo, hi, begin int64, parser LogParser) (int64, error) {
+	pos := lo
+	for pos < hi {
+		line, next, ok := readLineAt(file, pos, hi)
+		if !ok {
+			break
+		}
+		if item, err := parser.ParseLine(line); err == nil && item.Time >= begin {
+			return pos, nil
+		}
+		if next <= pos {
+			break
+		}
+		pos = next
+	}
+	return hi, nil
+}
+
+// firstParseableLineAt aligns to the next line boundary at or after
+// from, then tries up to seekMaxSkipTries lines within [from, limit),
+// skipping any that fail to parse, and returns the start offset of the
+// first one parser accepts.
+func firstParseableLineAt(file *os.File, from, limit int64, parser LogParser) (int64, *pb.LogMessage, bool) {
+	lineStart, ok := nextLineStart(file, from, limit)
+	if !ok {
+		return 0, nil, false
+	}
+	for tries := 0; lineStart < limit && tries < seekMaxSkipTries; tries++ {
+		line, next, ok := readLineAt(file, lineStart, limit)
+		if !ok {
+			return 0, nil, false
+		}
+		if item, err := parser.ParseLine(line); err == nil {
+			return lineStart, item, true
+		}
+		if next <= lineStart {
+			return 0, nil, false
+		}
+		lineStart = next
+	}
+	return 0, nil, false
+}
+
+// nextLineStart returns the offset of the first byte after the next '\n'
+// at or after from, bounded by limit, so reads that start mid-line can
+// align themselves onto a real line boundary. from == 0 is already a
+// line start.
+func nextLineStart(file *os.File, from, limit int64) (int64, bool) {
+	if from <= 0 {
+		return 0, true
+	}
+	if from >= limit {
+		return 0, false
+	}
+	sr := io.NewSectionReader(file, from, limit-from)
+	br := bufio.NewReader(sr)
+	prefix, err := br.ReadString('\n')
+	if err != nil {
+		if err == io.EOF && strings.HasSuffix(prefix, "\n") {
+			// unreachable: ReadString only returns EOF without the
+			// delimiter when no delimiter was found.
+		}
+		return 0, false
+	}
+	return from + int64(len(prefix)), true
+}
+
+// readLineAt reads the line starting at offset, bounded by limit,
+// returning its contents (without the trailing newline) and the offset
+// of the byte after it. A final line with no trailing newline before
+// limit is still returned, with next == limit.
+func readLineAt(file *os.File, offset, limit int64) (string, int64, bool) {
+	if offset >= limit {
+		return "", 0, false
+	}
+	sr := io.NewSectionReader(file, offset, limit-offset)
+	br := bufio.NewReader(sr)
+	line, err := br.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", 0, false
+	}
+	if err == io.EOF && line == "" {
+		return "", 0, false
+	}
+	return strings.TrimRight(line, "\r\n"), offset + int64(len(line)), true
+}
@@ -0,0 +1,158 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sysutil
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeSeekTimeTestFile writes n bracket-format lines one second apart
+// starting at startMs (an unparseable line instead at every index in
+// corruptAt), closes the file, and returns the timestamp of line i for
+// every i in [0, n).
+func writeSeekTimeTestFile(t *testing.T, path string, startMs int64, n int, corruptAt map[int]bool) []int64 {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	times := make([]int64, n)
+	for i := 0; i < n; i++ {
+		ms := startMs + int64(i)*1000
+		times[i] = ms
+		if corruptAt[i] {
+			if _, err := fmt.Fprintf(f, "garbage line %d without a timestamp\n", i); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		ts := time.Unix(0, ms*int64(time.Millisecond)).In(time.FixedZone("", -4*60*60))
+		if _, err := fmt.Fprintf(f, "[%s] [INFO] [printer.go:41] [\"line %d\"]\n", ts.Format(TimeStampLayout), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return times
+}
+
+func TestSeekToTimeMonotonic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tidb.log")
+	const n = 5000
+	startMs, err := parseTimeStamp("2019/08/26 00:00:00.000 -04:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	times := writeSeekTimeTestFile(t, path, startMs, n, nil)
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	parser := bracketLogParser{}
+
+	for _, target := range []int{0, 1, n / 2, n - 1} {
+		offset, err := seekToTime(f, times[target], parser)
+		if err != nil {
+			t.Fatalf("seekToTime(%d): %v", target, err)
+		}
+		item, _, ok := readLineAt(f, offset, mustFileSize(t, f))
+		if !ok {
+			t.Fatalf("no line at offset %d for target %d", offset, target)
+		}
+		got, err := parser.ParseLine(item)
+		if err != nil {
+			t.Fatalf("ParseLine: %v", err)
+		}
+		if got.Time != times[target] {
+			t.Errorf("target %d: seekToTime landed on time %d, want %d", target, got.Time, times[target])
+		}
+	}
+}
+
+func TestSeekToTimeSkipsCorruptLines(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tidb.log")
+	const n = 2000
+	startMs, err := parseTimeStamp("2019/08/26 00:00:00.000 -04:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupt := map[int]bool{n / 2: true, n/2 + 1: true}
+	times := writeSeekTimeTestFile(t, path, startMs, n, corrupt)
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	parser := bracketLogParser{}
+
+	target := n/2 + 2
+	offset, err := seekToTime(f, times[target], parser)
+	if err != nil {
+		t.Fatalf("seekToTime: %v", err)
+	}
+	line, _, ok := readLineAt(f, offset, mustFileSize(t, f))
+	if !ok {
+		t.Fatalf("no line at offset %d", offset)
+	}
+	item, err := parser.ParseLine(line)
+	if err != nil {
+		t.Fatalf("ParseLine: %v", err)
+	}
+	if item.Time < times[target] {
+		t.Errorf("seekToTime landed on time %d, which is before the target %d", item.Time, times[target])
+	}
+}
+
+func TestSeekToTimeFirstLineAlreadySatisfies(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tidb.log")
+	startMs, err := parseTimeStamp("2019/08/26 00:00:00.000 -04:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeSeekTimeTestFile(t, path, startMs, 10, nil)
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	offset, err := seekToTime(f, startMs-1000, bracketLogParser{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset != 0 {
+		t.Errorf("offset = %d, want 0 when the first line already satisfies begin", offset)
+	}
+}
+
+func mustFileSize(t *testing.T, f *os.File) int64 {
+	t.Helper()
+	stat, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stat.Size()
+}
@@ -0,0 +1,115 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sysutil
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompressLogFileZstdChunkedRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "tidb.log")
+	dst := filepath.Join(dir, "tidb.log.zst")
+	if err := os.WriteFile(src, []byte(compressTestLog), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := CompressLogFileZstdChunked(src, dst); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	codec := newZstdChunkedCodec()
+	rc, err := codec.NewReader(f, stat.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	got, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != compressTestLog {
+		t.Errorf("round-tripped content = %q, want %q", got, compressTestLog)
+	}
+
+	wantFirst, err := parseTimeStamp("2019/08/26 06:19:13.011 -04:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantLast, err := parseTimeStamp("2019/08/26 08:20:23.815 -04:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	firstTime, lastTime, err := codec.TimeRange(f, stat.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if firstTime != wantFirst || lastTime != wantLast {
+		t.Errorf("TimeRange = (%d, %d), want (%d, %d)", firstTime, lastTime, wantFirst, wantLast)
+	}
+}
+
+// TestCompressLogFileZstdChunkedFallsBackWhenUnparseable mirrors
+// TestCompressLogFileUnparseableSourceOmitsMetadata for the zstd:chunked
+// codec: a source that doesn't sniff must still be archived (just
+// without per-chunk timestamps), not dropped.
+func TestCompressLogFileZstdChunkedFallsBackWhenUnparseable(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "garbage.log")
+	dst := filepath.Join(dir, "garbage.log.zst")
+	content := "not a valid log line\nnor is this\n"
+	if err := os.WriteFile(src, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := CompressLogFileZstdChunked(src, dst); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(dst)
+	if err != nil {
+		t.Fatalf("dst was not created: %v", err)
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	codec := newZstdChunkedCodec()
+	rc, err := codec.NewReader(f, stat.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	got, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != content {
+		t.Errorf("round-tripped content = %q, want %q", got, content)
+	}
+}